@@ -1,12 +1,15 @@
 package mr
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -19,13 +22,26 @@ const (
 	TaskCompleted
 )
 
+// Attempt records one worker's attempt at executing a task. A task
+// normally has at most one Attempt, but gains a second when it is
+// speculatively re-dispatched as a backup task near the end of a phase.
+type Attempt struct {
+	WorkerId  WorkerId
+	StartTime time.Time
+}
+
 type Task struct {
 	State     TaskState
-	StartTime time.Time
-	FileNames []string // For map: single file, for reduce: all intermediate files
-	MapTaskId int      // For reduce tasks
+	Attempts  []Attempt
+	FileNames []string // For map tasks: the input file to process. Reduce tasks fetch their inputs over HTTP instead (see Location/locationsFor) and leave this unset.
 }
 
+// defaultBackupThreshold is the fraction of a phase's tasks that may still
+// be idle or in-progress before the coordinator starts handing out backup
+// attempts for tasks that are already in progress, per the paper's
+// straggler mitigation.
+const defaultBackupThreshold = 0.1
+
 type Coordinator struct {
 	// Your definitions here.
 	mu          sync.Mutex
@@ -35,6 +51,179 @@ type Coordinator struct {
 	nMap        int
 	mapDone     bool
 	allDone     bool
+
+	// BackupThreshold controls how aggressively the coordinator dispatches
+	// backup tasks: once no more than BackupThreshold*len(tasks) tasks in a
+	// phase are still idle or in-progress, in-progress tasks become
+	// eligible for a second, speculative attempt.
+	BackupThreshold float64
+
+	nextWorkerId WorkerId
+	workers      map[WorkerId]*workerInfo
+
+	// mapLocations[mapId] is the "host:port" of the map worker serving
+	// mapId's intermediate files, set once that map task completes.
+	mapLocations []string
+
+	// checkpointPath is where job state is periodically saved so a
+	// crashed coordinator can be resumed with ResumeCoordinator. Empty
+	// disables checkpointing.
+	checkpointPath string
+}
+
+// checkpointInterval is how often the coordinator snapshots its state to
+// checkpointPath, in addition to checkpointing on every successful
+// ReportTask.
+const checkpointInterval = 5 * time.Second
+
+// checkpoint is the on-disk snapshot of coordinator state used to resume
+// a crashed coordinator (see ResumeCoordinator). Completed reduce tasks'
+// mr-out-X files live on the shared FS, but completed map tasks' output
+// only lives on the owning map worker's own disk, served over HTTP (see
+// Location) -- so MapLocations has to be checkpointed too. Without it, a
+// resumed coordinator would hand reduce workers locations built from an
+// empty host, forcing every completed map to be needlessly re-run one at
+// a time via the FailedMapId retry path, instead of being fetched from a
+// worker that may still be alive and serving it just fine. NextWorkerId
+// is checkpointed for the same reason ids mustn't be reused: a worker
+// that's still alive across the coordinator's restart keeps using its old
+// id, so newly-registering workers can't be allowed to start back at 0
+// and collide with it.
+type checkpoint struct {
+	MapTasks     []Task
+	ReduceTasks  []Task
+	NReduce      int
+	NMap         int
+	MapDone      bool
+	AllDone      bool
+	MapLocations []string
+	NextWorkerId WorkerId
+}
+
+// writeCheckpoint snapshots coordinator state to path via a temp file and
+// atomic rename, so a reader never observes a partial write. Checkpointing
+// is best-effort: a failure is logged, not fatal, since losing the latest
+// snapshot only costs progress on resume, not correctness.
+func (c *Coordinator) writeCheckpoint(path string) {
+	c.mu.Lock()
+	snap := checkpoint{
+		MapTasks:     c.mapTasks,
+		ReduceTasks:  c.reduceTasks,
+		NReduce:      c.nReduce,
+		NMap:         c.nMap,
+		MapDone:      c.mapDone,
+		AllDone:      c.allDone,
+		MapLocations: c.mapLocations,
+		NextWorkerId: c.nextWorkerId,
+	}
+	// snap shares its slices with the live state, so marshal it before
+	// releasing the lock.
+	data, err := json.MarshalIndent(&snap, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("checkpoint: marshal failed: %v", err)
+		return
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), "mr-checkpoint-")
+	if err != nil {
+		log.Printf("checkpoint: cannot create temp file: %v", err)
+		return
+	}
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		os.Remove(tempFile.Name())
+		log.Printf("checkpoint: cannot write temp file: %v", err)
+		return
+	}
+	tempFile.Close()
+
+	if err := os.Rename(tempFile.Name(), path); err != nil {
+		log.Printf("checkpoint: cannot rename to %v: %v", path, err)
+		os.Remove(tempFile.Name())
+	}
+}
+
+// runCheckpointing periodically writes a checkpoint until the job is
+// done.
+func (c *Coordinator) runCheckpointing(path string) {
+	for {
+		time.Sleep(checkpointInterval)
+		c.writeCheckpoint(path)
+
+		c.mu.Lock()
+		done := c.allDone
+		c.mu.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// workerInfo tracks liveness for a registered worker.
+type workerInfo struct {
+	LastSeen time.Time
+}
+
+// RegisterWorker assigns a new worker a stable id, returned to Worker() on
+// startup and used on every subsequent RPC so the coordinator can tell its
+// attempts apart from other workers'.
+func (c *Coordinator) RegisterWorker(args *RegisterWorkerArgs, reply *RegisterWorkerReply) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextWorkerId++
+	id := c.nextWorkerId
+	c.workers[id] = &workerInfo{LastSeen: time.Now()}
+	reply.WorkerId = id
+	return nil
+}
+
+// Heartbeat records that a worker is still alive.
+func (c *Coordinator) Heartbeat(args *HeartbeatArgs, reply *HeartbeatReply) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if w, ok := c.workers[args.WorkerId]; ok {
+		w.LastSeen = time.Now()
+	}
+	return nil
+}
+
+// monitorWorkers runs in the background, declaring a worker dead once it
+// misses missedHeartbeats heartbeats and immediately freeing its
+// in-progress tasks for reassignment, instead of waiting on the task
+// deadline in checkTimeouts.
+func (c *Coordinator) monitorWorkers() {
+	for {
+		time.Sleep(heartbeatInterval)
+
+		c.mu.Lock()
+		deadline := time.Now().Add(-missedHeartbeats * heartbeatInterval)
+		for id, w := range c.workers {
+			if w.LastSeen.Before(deadline) {
+				c.freeAttemptsOf(c.mapTasks, id)
+				c.freeAttemptsOf(c.reduceTasks, id)
+				delete(c.workers, id)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// freeAttemptsOf drops workerId's attempt at any in-progress task in
+// tasks, putting the task back to TaskIdle if no other attempt is left
+// running.
+func (c *Coordinator) freeAttemptsOf(tasks []Task, workerId WorkerId) {
+	for i := range tasks {
+		if tasks[i].State != TaskInProgress {
+			continue
+		}
+		tasks[i].Attempts = dropAttempt(tasks[i].Attempts, workerId)
+		if len(tasks[i].Attempts) == 0 {
+			tasks[i].State = TaskIdle
+		}
+	}
 }
 
 // Your code here -- RPC handlers for the worker to call.
@@ -48,16 +237,13 @@ func (c *Coordinator) GetTask(args *GetTaskArgs, reply *GetTaskReply) error {
 
 	// First, assign map tasks
 	if !c.mapDone {
-		for i, task := range c.mapTasks {
-			if task.State == TaskIdle {
-				c.mapTasks[i].State = TaskInProgress
-				c.mapTasks[i].StartTime = time.Now()
-				reply.TaskType = MapTask
-				reply.TaskId = i
-				reply.FileName = task.FileNames[0]
-				reply.NReduce = c.nReduce
-				return nil
-			}
+		if i, ok := c.findAssignable(c.mapTasks); ok {
+			c.dispatch(&c.mapTasks[i], args.WorkerId)
+			reply.TaskType = MapTask
+			reply.TaskId = i
+			reply.FileName = c.mapTasks[i].FileNames[0]
+			reply.NReduce = c.nReduce
+			return nil
 		}
 		// Check if all maps are completed
 		allMapsDone := true
@@ -77,23 +263,13 @@ func (c *Coordinator) GetTask(args *GetTaskArgs, reply *GetTaskReply) error {
 	}
 
 	// All maps done, assign reduce tasks
-	for i, task := range c.reduceTasks {
-		if task.State == TaskIdle {
-			// Collect all intermediate files for this reduce task
-			fileNames := []string{}
-			for mapId := 0; mapId < c.nMap; mapId++ {
-				fileName := fmt.Sprintf("mr-%d-%d", mapId, i)
-				fileNames = append(fileNames, fileName)
-			}
-			c.reduceTasks[i].State = TaskInProgress
-			c.reduceTasks[i].StartTime = time.Now()
-			c.reduceTasks[i].FileNames = fileNames
-			reply.TaskType = ReduceTask
-			reply.TaskId = i
-			reply.FileNames = fileNames
-			reply.NReduce = c.nReduce
-			return nil
-		}
+	if i, ok := c.findAssignable(c.reduceTasks); ok {
+		c.dispatch(&c.reduceTasks[i], args.WorkerId)
+		reply.TaskType = ReduceTask
+		reply.TaskId = i
+		reply.Locations = c.locationsFor(i)
+		reply.NReduce = c.nReduce
+		return nil
 	}
 
 	// Check if all reduces are done
@@ -115,54 +291,156 @@ func (c *Coordinator) GetTask(args *GetTaskArgs, reply *GetTaskReply) error {
 	return nil
 }
 
+// findAssignable looks for a task in tasks that a worker can be handed
+// next: an idle task if one exists, otherwise, once the phase is close to
+// finishing, an in-progress task that hasn't yet been given a backup
+// attempt.
+func (c *Coordinator) findAssignable(tasks []Task) (int, bool) {
+	for i, task := range tasks {
+		if task.State == TaskIdle {
+			return i, true
+		}
+	}
+
+	remaining := 0
+	for _, task := range tasks {
+		if task.State != TaskCompleted {
+			remaining++
+		}
+	}
+	if remaining == 0 || float64(remaining) > c.BackupThreshold*float64(len(tasks)) {
+		return -1, false
+	}
+
+	for i, task := range tasks {
+		if task.State == TaskInProgress && len(task.Attempts) < 2 {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// locationsFor builds the list of HTTP locations a reduce worker should
+// fetch reduceId's intermediate data from, one per completed map task.
+func (c *Coordinator) locationsFor(reduceId int) []Location {
+	locations := make([]Location, c.nMap)
+	for mapId := 0; mapId < c.nMap; mapId++ {
+		locations[mapId] = Location{
+			MapId: mapId,
+			URL:   fmt.Sprintf("http://%s/mr-%d-%d", c.mapLocations[mapId], mapId, reduceId),
+		}
+	}
+	return locations
+}
+
+// dispatch marks task in-progress and records a new attempt for it. When
+// task is already in-progress, this adds a second, backup attempt.
+func (c *Coordinator) dispatch(task *Task, workerId WorkerId) {
+	task.State = TaskInProgress
+	task.Attempts = append(task.Attempts, Attempt{WorkerId: workerId, StartTime: time.Now()})
+}
+
 func (c *Coordinator) ReportTask(args *ReportTaskArgs, reply *ReportTaskReply) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if args.TaskType == MapTask {
 		if args.TaskId >= 0 && args.TaskId < len(c.mapTasks) {
-			if args.Success {
-				c.mapTasks[args.TaskId].State = TaskCompleted
-			} else {
-				// Task failed, reset to idle so it can be retried
-				c.mapTasks[args.TaskId].State = TaskIdle
-			}
+			c.recordReport(&c.mapTasks[args.TaskId], args)
 		}
 	} else if args.TaskType == ReduceTask {
 		if args.TaskId >= 0 && args.TaskId < len(c.reduceTasks) {
-			if args.Success {
-				c.reduceTasks[args.TaskId].State = TaskCompleted
-			} else {
-				// Task failed, reset to idle so it can be retried
-				c.reduceTasks[args.TaskId].State = TaskIdle
-			}
+			c.recordReport(&c.reduceTasks[args.TaskId], args)
 		}
 	}
+	c.mu.Unlock()
+
+	if args.Success && c.checkpointPath != "" {
+		c.writeCheckpoint(c.checkpointPath)
+	}
 
 	return nil
 }
 
+// recordReport applies the result of one worker's attempt at task. The
+// first successful attempt wins: once a task is TaskCompleted, later
+// reports are late results from a losing backup attempt (including its
+// already-renamed intermediate/output files) and are simply discarded.
+func (c *Coordinator) recordReport(task *Task, args *ReportTaskArgs) {
+	if task.State == TaskCompleted {
+		return
+	}
+
+	if args.Success {
+		task.State = TaskCompleted
+		if args.TaskType == MapTask {
+			c.mapLocations[args.TaskId] = args.HostPort
+		}
+		return
+	}
+
+	if args.TaskType == ReduceTask && args.FailedMapId != nil {
+		// The reduce worker couldn't fetch one map's intermediate
+		// output (its worker likely died); re-run that map task so its
+		// Location gets refreshed, instead of endlessly retrying the
+		// reduce task against data that's gone.
+		c.requeueMap(*args.FailedMapId)
+	}
+
+	// This attempt failed; drop it. If a backup attempt is still running,
+	// leave the task in progress for it, otherwise make the task
+	// assignable again.
+	task.Attempts = dropAttempt(task.Attempts, args.WorkerId)
+	if len(task.Attempts) == 0 {
+		task.State = TaskIdle
+	}
+}
+
+// requeueMap makes a previously-completed map task assignable again,
+// because its intermediate output is no longer reachable.
+func (c *Coordinator) requeueMap(mapId int) {
+	if mapId < 0 || mapId >= len(c.mapTasks) {
+		return
+	}
+	c.mapTasks[mapId].State = TaskIdle
+	c.mapTasks[mapId].Attempts = nil
+	c.mapLocations[mapId] = ""
+	c.mapDone = false
+}
+
+func dropAttempt(attempts []Attempt, workerId WorkerId) []Attempt {
+	remaining := attempts[:0]
+	for _, a := range attempts {
+		if a.WorkerId != workerId {
+			remaining = append(remaining, a)
+		}
+	}
+	return remaining
+}
+
 func (c *Coordinator) checkTimeouts() {
 	timeout := 10 * time.Second
 	now := time.Now()
 
-	// Check map task timeouts
-	for i := range c.mapTasks {
-		if c.mapTasks[i].State == TaskInProgress {
-			if now.Sub(c.mapTasks[i].StartTime) > timeout {
-				c.mapTasks[i].State = TaskIdle
+	expire := func(tasks []Task) {
+		for i := range tasks {
+			if tasks[i].State != TaskInProgress {
+				continue
 			}
-		}
-	}
-
-	// Check reduce task timeouts
-	for i := range c.reduceTasks {
-		if c.reduceTasks[i].State == TaskInProgress {
-			if now.Sub(c.reduceTasks[i].StartTime) > timeout {
-				c.reduceTasks[i].State = TaskIdle
+			stillRunning := false
+			for _, a := range tasks[i].Attempts {
+				if now.Sub(a.StartTime) <= timeout {
+					stillRunning = true
+					break
+				}
+			}
+			if !stillRunning {
+				tasks[i].State = TaskIdle
+				tasks[i].Attempts = nil
 			}
 		}
 	}
+
+	expire(c.mapTasks)
+	expire(c.reduceTasks)
 }
 
 // an example RPC handler.
@@ -185,6 +463,60 @@ func (c *Coordinator) server() {
 		log.Fatal("listen error:", e)
 	}
 	go http.Serve(l, nil)
+
+	c.serveStatus()
+}
+
+// serveStatus starts a /status HTTP endpoint on its own regular TCP port,
+// listing live workers and their current task assignment. It's only for
+// lab debugging, so a listen failure (e.g. sandboxed environment) is
+// logged and otherwise ignored.
+func (c *Coordinator) serveStatus() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", c.statusHandler)
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		log.Printf("status endpoint disabled: %v", e)
+		return
+	}
+	log.Printf("status endpoint listening on http://%s/status", l.Addr())
+	go http.Serve(l, mux)
+}
+
+func (c *Coordinator) statusHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "workers:\n")
+	for id, info := range c.workers {
+		fmt.Fprintf(w, "  %d\tlast seen %s\t%s\n", id, info.LastSeen.Format(time.RFC3339), c.assignmentOf(id))
+	}
+}
+
+// assignmentOf describes what, if anything, workerId is currently working
+// on.
+func (c *Coordinator) assignmentOf(workerId WorkerId) string {
+	if desc, ok := assignmentIn(c.mapTasks, "map", workerId); ok {
+		return desc
+	}
+	if desc, ok := assignmentIn(c.reduceTasks, "reduce", workerId); ok {
+		return desc
+	}
+	return "idle"
+}
+
+func assignmentIn(tasks []Task, kind string, workerId WorkerId) (string, bool) {
+	for i, task := range tasks {
+		if task.State != TaskInProgress {
+			continue
+		}
+		for _, a := range task.Attempts {
+			if a.WorkerId == workerId {
+				return fmt.Sprintf("%s task %d", kind, i), true
+			}
+		}
+	}
+	return "", false
 }
 
 // main/mrcoordinator.go calls Done() periodically to find out
@@ -198,8 +530,11 @@ func (c *Coordinator) Done() bool {
 
 // create a Coordinator.
 // main/mrcoordinator.go calls this function.
-// nReduce is the number of reduce tasks to use.
-func MakeCoordinator(files []string, nReduce int) *Coordinator {
+// nReduce is the number of reduce tasks to use. checkpointPath, if
+// non-empty, is where job state is periodically saved so a crashed
+// coordinator can be restarted with ResumeCoordinator instead of losing
+// all its progress; pass "" to disable checkpointing.
+func MakeCoordinator(files []string, nReduce int, checkpointPath string) *Coordinator {
 	c := Coordinator{}
 
 	// Your code here.
@@ -207,6 +542,10 @@ func MakeCoordinator(files []string, nReduce int) *Coordinator {
 	c.nMap = len(files)
 	c.mapDone = false
 	c.allDone = false
+	c.BackupThreshold = defaultBackupThreshold
+	c.workers = make(map[WorkerId]*workerInfo)
+	c.mapLocations = make([]string, len(files))
+	c.checkpointPath = checkpointPath
 
 	// Initialize map tasks
 	c.mapTasks = make([]Task, len(files))
@@ -225,6 +564,80 @@ func MakeCoordinator(files []string, nReduce int) *Coordinator {
 		}
 	}
 
-	c.server()
+	c.start()
 	return &c
 }
+
+// ResumeCoordinator rebuilds a Coordinator from a checkpoint written by a
+// previous run (see MakeCoordinator) and starts it serving. It's meant
+// for a main package's --resume flag.
+func ResumeCoordinator(checkpointPath string) (*Coordinator, error) {
+	c, err := resumeFromCheckpoint(checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	c.start()
+	return c, nil
+}
+
+// resumeFromCheckpoint rebuilds a Coordinator's state from checkpointPath
+// without starting its servers or background goroutines, so tests can
+// exercise the resume logic itself without opening real sockets.
+// Completed tasks stay completed, and their MapLocations/mr-out-X files
+// are trusted to still be reachable -- if one isn't, the existing
+// FailedMapId retry path re-runs just that one map task. Every
+// in-progress task's attempts are dropped back to TaskIdle, since the
+// workers that were attempting them may be long gone.
+func resumeFromCheckpoint(checkpointPath string) (*Coordinator, error) {
+	data, err := ioutil.ReadFile(checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap checkpoint
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+
+	mapLocations := snap.MapLocations
+	if len(mapLocations) != snap.NMap {
+		mapLocations = make([]string, snap.NMap)
+	}
+
+	c := Coordinator{
+		mapTasks:        snap.MapTasks,
+		reduceTasks:     snap.ReduceTasks,
+		nReduce:         snap.NReduce,
+		nMap:            snap.NMap,
+		mapDone:         snap.MapDone,
+		allDone:         snap.AllDone,
+		BackupThreshold: defaultBackupThreshold,
+		nextWorkerId:    snap.NextWorkerId,
+		workers:         make(map[WorkerId]*workerInfo),
+		mapLocations:    mapLocations,
+		checkpointPath:  checkpointPath,
+	}
+
+	resetInProgress := func(tasks []Task) {
+		for i := range tasks {
+			if tasks[i].State == TaskInProgress {
+				tasks[i].State = TaskIdle
+				tasks[i].Attempts = nil
+			}
+		}
+	}
+	resetInProgress(c.mapTasks)
+	resetInProgress(c.reduceTasks)
+
+	return &c, nil
+}
+
+// start brings up the RPC/status servers and the coordinator's background
+// goroutines. Shared by MakeCoordinator and ResumeCoordinator.
+func (c *Coordinator) start() {
+	c.server()
+	go c.monitorWorkers()
+	if c.checkpointPath != "" {
+		go c.runCheckpointing(c.checkpointPath)
+	}
+}
@@ -0,0 +1,162 @@
+package mr
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCoordinator builds a Coordinator directly (rather than via
+// MakeCoordinator) so tests can drive its RPC handlers without opening
+// real sockets.
+func newTestCoordinator(nMap, nReduce int) *Coordinator {
+	c := &Coordinator{
+		mapTasks:        make([]Task, nMap),
+		reduceTasks:     make([]Task, nReduce),
+		nReduce:         nReduce,
+		nMap:            nMap,
+		BackupThreshold: 1.0, // always eligible for a backup once nothing's idle
+		workers:         make(map[WorkerId]*workerInfo),
+		mapLocations:    make([]string, nMap),
+	}
+	for i := range c.mapTasks {
+		c.mapTasks[i] = Task{State: TaskIdle, FileNames: []string{filepath.Join("in", "f")}}
+	}
+	for i := range c.reduceTasks {
+		c.reduceTasks[i] = Task{State: TaskIdle}
+	}
+	return c
+}
+
+// TestBackupTaskDispatchedNearPhaseCompletion exercises chunk0-1's
+// straggler mitigation: once a phase has nothing idle left, a second
+// worker asking for work should be handed a backup attempt at an
+// in-progress task instead of NoTask, and the first successful report
+// should win over a later one from the losing attempt.
+func TestBackupTaskDispatchedNearPhaseCompletion(t *testing.T) {
+	c := newTestCoordinator(1, 1)
+
+	var reply1 GetTaskReply
+	if err := c.GetTask(&GetTaskArgs{WorkerId: 1}, &reply1); err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if reply1.TaskType != MapTask || reply1.TaskId != 0 {
+		t.Fatalf("expected worker 1 to get map task 0, got %+v", reply1)
+	}
+
+	var reply2 GetTaskReply
+	if err := c.GetTask(&GetTaskArgs{WorkerId: 2}, &reply2); err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if reply2.TaskType != MapTask || reply2.TaskId != 0 {
+		t.Fatalf("expected worker 2 to get a backup attempt at map task 0, got %+v", reply2)
+	}
+	if len(c.mapTasks[0].Attempts) != 2 {
+		t.Fatalf("expected 2 concurrent attempts at map task 0, got %d", len(c.mapTasks[0].Attempts))
+	}
+
+	// The first successful report wins...
+	winner := ReportTaskArgs{TaskType: MapTask, TaskId: 0, WorkerId: 2, Success: true, HostPort: "127.0.0.1:9002"}
+	if err := c.ReportTask(&winner, &ReportTaskReply{}); err != nil {
+		t.Fatalf("ReportTask (winner): %v", err)
+	}
+	if c.mapTasks[0].State != TaskCompleted {
+		t.Fatalf("expected map task 0 to be completed, got state %v", c.mapTasks[0].State)
+	}
+
+	// ...and a late report from the losing attempt is discarded.
+	loser := ReportTaskArgs{TaskType: MapTask, TaskId: 0, WorkerId: 1, Success: true, HostPort: "127.0.0.1:9001"}
+	if err := c.ReportTask(&loser, &ReportTaskReply{}); err != nil {
+		t.Fatalf("ReportTask (loser): %v", err)
+	}
+	if c.mapLocations[0] != winner.HostPort {
+		t.Fatalf("late report from losing attempt overwrote mapLocations: got %v, want %v", c.mapLocations[0], winner.HostPort)
+	}
+}
+
+// TestDeadWorkerTasksFreedImmediately exercises chunk0-2: a worker that
+// has missed its heartbeats should have its in-progress tasks freed for
+// reassignment without waiting on the 10s task timeout.
+func TestDeadWorkerTasksFreedImmediately(t *testing.T) {
+	c := newTestCoordinator(1, 1)
+
+	c.workers[1] = &workerInfo{LastSeen: time.Now().Add(-missedHeartbeats * heartbeatInterval * 2)}
+	c.dispatch(&c.mapTasks[0], 1)
+	if c.mapTasks[0].State != TaskInProgress {
+		t.Fatalf("expected map task 0 to be in progress")
+	}
+
+	c.freeAttemptsOf(c.mapTasks, 1)
+
+	if c.mapTasks[0].State != TaskIdle {
+		t.Fatalf("expected map task 0 to be freed back to idle, got state %v", c.mapTasks[0].State)
+	}
+	if len(c.mapTasks[0].Attempts) != 0 {
+		t.Fatalf("expected no attempts left on map task 0, got %d", len(c.mapTasks[0].Attempts))
+	}
+}
+
+// TestFailedFetchRequeuesMap exercises chunk0-4's retry path: when a
+// reduce worker can't fetch a map's intermediate output over HTTP, it
+// reports FailedMapId, and the coordinator should make that map task
+// assignable again (clearing its stale location) rather than just
+// retrying the reduce task against data that's gone.
+func TestFailedFetchRequeuesMap(t *testing.T) {
+	c := newTestCoordinator(1, 1)
+	c.mapTasks[0].State = TaskCompleted
+	c.mapLocations[0] = "127.0.0.1:9001"
+	c.mapDone = true
+	c.dispatch(&c.reduceTasks[0], 5)
+
+	failedMapId := 0
+	args := ReportTaskArgs{TaskType: ReduceTask, TaskId: 0, WorkerId: 5, Success: false, FailedMapId: &failedMapId}
+	if err := c.ReportTask(&args, &ReportTaskReply{}); err != nil {
+		t.Fatalf("ReportTask: %v", err)
+	}
+
+	if c.mapTasks[0].State != TaskIdle {
+		t.Fatalf("expected map task 0 to be requeued idle, got state %v", c.mapTasks[0].State)
+	}
+	if c.mapLocations[0] != "" {
+		t.Fatalf("expected stale location to be cleared, got %v", c.mapLocations[0])
+	}
+	if c.mapDone {
+		t.Fatalf("expected mapDone to be cleared so the requeued map task gets reassigned")
+	}
+}
+
+// TestCheckpointResumeRoundTrip exercises chunk0-6: a coordinator's state
+// -- including completed tasks' MapLocations and the next id to hand
+// out -- should survive a checkpoint/resume round trip, while
+// in-progress tasks come back idle.
+func TestCheckpointResumeRoundTrip(t *testing.T) {
+	c := newTestCoordinator(2, 1)
+	c.mapTasks[0].State = TaskCompleted
+	c.mapLocations[0] = "127.0.0.1:9001"
+	c.dispatch(&c.mapTasks[1], 7)
+	c.nextWorkerId = 7
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	c.writeCheckpoint(path)
+
+	resumed, err := resumeFromCheckpoint(path)
+	if err != nil {
+		t.Fatalf("resumeFromCheckpoint: %v", err)
+	}
+
+	if resumed.mapTasks[0].State != TaskCompleted {
+		t.Fatalf("expected completed map task 0 to stay completed, got state %v", resumed.mapTasks[0].State)
+	}
+	if resumed.mapLocations[0] != "127.0.0.1:9001" {
+		t.Fatalf("expected mapLocations to survive resume, got %v", resumed.mapLocations[0])
+	}
+	if resumed.mapTasks[1].State != TaskIdle {
+		t.Fatalf("expected in-progress map task 1 to come back idle, got state %v", resumed.mapTasks[1].State)
+	}
+	if len(resumed.mapTasks[1].Attempts) != 0 {
+		t.Fatalf("expected in-progress map task 1's attempts to be dropped, got %d", len(resumed.mapTasks[1].Attempts))
+	}
+	if resumed.nextWorkerId != 7 {
+		t.Fatalf("expected nextWorkerId to survive resume, got %d", resumed.nextWorkerId)
+	}
+}
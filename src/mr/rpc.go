@@ -9,6 +9,7 @@ package mr
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 //
@@ -35,23 +36,70 @@ const (
 	NoTask
 )
 
+// WorkerId identifies a worker process across RPCs, so the coordinator can
+// tell which attempt at a task a given report belongs to.
+type WorkerId int64
+
+// heartbeatInterval is how often a worker pings the coordinator to prove
+// it's still alive.
+const heartbeatInterval = 2 * time.Second
+
+// missedHeartbeats is how many heartbeat intervals a worker may miss
+// before the coordinator considers it dead and reassigns its tasks.
+const missedHeartbeats = 3
+
+type RegisterWorkerArgs struct {
+	// Empty - worker just asks for an id
+}
+
+type RegisterWorkerReply struct {
+	WorkerId WorkerId
+}
+
+type HeartbeatArgs struct {
+	WorkerId WorkerId
+}
+
+type HeartbeatReply struct {
+	// Empty - just acknowledge
+}
+
 type GetTaskArgs struct {
-	// Empty - worker just requests a task
+	WorkerId WorkerId
+}
+
+// Location says where a completed map task's output for one reduce
+// partition can be fetched: the owning map worker serves it over HTTP
+// from its own local disk rather than everyone sharing a filesystem.
+type Location struct {
+	MapId int
+	URL   string
 }
 
 type GetTaskReply struct {
-	TaskType  TaskType
-	TaskId    int
-	FileName  string   // For map tasks
-	FileNames []string // For reduce tasks
-	NReduce   int
-	MapTaskId int // For reduce tasks, to know which map outputs to read
+	TaskType TaskType
+	TaskId   int
+	FileName string // For map tasks
+	NReduce  int
+
+	Locations []Location // For reduce tasks, where to fetch each map's output
 }
 
 type ReportTaskArgs struct {
 	TaskType TaskType
 	TaskId   int
+	WorkerId WorkerId
 	Success  bool
+
+	// HostPort is where this worker serves its mr-<TaskId>-* files,
+	// reported alongside a successful map task.
+	HostPort string
+
+	// FailedMapId is set when a reduce task fails because it couldn't
+	// fetch one particular map's output, so the coordinator knows to
+	// re-run that map task (and refresh its Location) instead of just
+	// retrying the reduce task against the same, still-missing data.
+	FailedMapId *int
 }
 
 type ReportTaskReply struct {
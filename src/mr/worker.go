@@ -1,17 +1,28 @@
 package mr
 
 import (
+	"container/heap"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"net/rpc"
 	"os"
 	"sort"
 	"time"
 )
 
+// MaxInMemoryBytes is the size above which sortBucket sorts one map
+// task's per-partition bucket as separate sorted runs of about this size
+// and merges them, instead of sorting it in one piece. The runs are kept
+// in memory and nothing is spilled to disk, so the whole bucket is still
+// held in memory. The reduce side does not use this setting.
+var MaxInMemoryBytes int64 = 64 << 20 // 64MB
+
 // Map functions return a slice of KeyValue.
 type KeyValue struct {
 	Key   string
@@ -26,13 +37,72 @@ func ihash(key string) int {
 	return int(h.Sum32() & 0x7fffffff)
 }
 
-// main/mrworker.go calls this function.
+// defaultPartition is used when a Worker isn't given its own partitionf.
+func defaultPartition(key string, nReduce int) int {
+	return ihash(key) % nReduce
+}
+
+// combine groups kva by key and applies combinef to each group's values,
+// the same way performReduceTask groups values for reducef. It mirrors
+// the paper's combiner semantics: same-shaped input and output, safe to
+// apply per map-worker before the reduce phase ever sees the data.
+//
+// combine sorts kva via sortBucket rather than a plain sort.Sort, so a
+// bucket larger than MaxInMemoryBytes is sorted in runs the same way it
+// would be without a combiner.
+func combine(kva []KeyValue, combinef func(string, []string) string) []KeyValue {
+	kva = sortBucket(kva)
+
+	combined := []KeyValue{}
+	i := 0
+	for i < len(kva) {
+		j := i + 1
+		for j < len(kva) && kva[j].Key == kva[i].Key {
+			j++
+		}
+		values := []string{}
+		for k := i; k < j; k++ {
+			values = append(values, kva[k].Value)
+		}
+		combined = append(combined, KeyValue{Key: kva[i].Key, Value: combinef(kva[i].Key, values)})
+		i = j
+	}
+	return combined
+}
+
+// main/mrworker.go calls this function. partitionf and combinef are
+// optional: a nil partitionf falls back to the default
+// ihash(key)%nReduce partitioning, and a nil combinef skips combining
+// entirely.
 func Worker(mapf func(string, string) []KeyValue,
-	reducef func(string, []string) string) {
+	reducef func(string, []string) string,
+	partitionf func(key string, nReduce int) int,
+	combinef func(key string, values []string) string) {
 
 	// Your worker implementation here.
+
+	// Register with the coordinator to get a stable id: the coordinator
+	// uses it to tell apart concurrent attempts at the same task, so it
+	// can accept the first successful report and ignore/discard the
+	// loser's, and to detect this worker's death via missed heartbeats.
+	workerId, ok := registerWorker()
+	if !ok {
+		// Coordinator is unreachable; nothing to do.
+		return
+	}
+	go sendHeartbeats(workerId)
+
+	// Serve this worker's own mr-<mapId>-* files over HTTP so reduce
+	// workers can fetch map output directly from local disk, instead of
+	// everyone sharing a filesystem.
+	hostPort, err := startIntermediateServer()
+	if err != nil {
+		log.Printf("cannot start intermediate file server: %v", err)
+		return
+	}
+
 	for {
-		args := GetTaskArgs{}
+		args := GetTaskArgs{WorkerId: workerId}
 		reply := GetTaskReply{}
 
 		ok := call("Coordinator.GetTask", &args, &reply)
@@ -43,11 +113,11 @@ func Worker(mapf func(string, string) []KeyValue,
 
 		switch reply.TaskType {
 		case MapTask:
-			success := performMapTask(reply.FileName, reply.TaskId, reply.NReduce, mapf)
-			reportTask(MapTask, reply.TaskId, success)
+			success := performMapTask(reply.FileName, reply.TaskId, reply.NReduce, mapf, partitionf, combinef)
+			reportMapTask(reply.TaskId, workerId, hostPort, success)
 		case ReduceTask:
-			success := performReduceTask(reply.TaskId, reply.FileNames, reducef)
-			reportTask(ReduceTask, reply.TaskId, success)
+			success, failedMapId := performReduceTask(reply.TaskId, reply.Locations, reducef)
+			reportReduceTask(reply.TaskId, workerId, failedMapId, success)
 		case ExitTask:
 			// Coordinator says all work is done
 			return
@@ -58,7 +128,44 @@ func Worker(mapf func(string, string) []KeyValue,
 	}
 }
 
-func performMapTask(filename string, mapTaskId int, nReduce int, mapf func(string, string) []KeyValue) bool {
+// startIntermediateServer serves this worker's mr-<mapId>-* files (from
+// its current working directory) over HTTP on an OS-assigned port, and
+// returns the "host:port" to report to the coordinator. It binds the
+// wildcard address rather than loopback: a reduce worker fetching this
+// over the network, possibly from another host entirely, needs an
+// address it can actually reach, not one that's only valid for
+// connections originating on this machine.
+func startIntermediateServer() (string, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return "", err
+	}
+	go http.Serve(l, http.FileServer(http.Dir(".")))
+
+	ip, err := outboundIP()
+	if err != nil {
+		l.Close()
+		return "", err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	return fmt.Sprintf("%s:%d", ip, port), nil
+}
+
+// outboundIP returns the address other hosts would use to reach this one,
+// by asking the kernel which local interface it would route through to
+// reach an external address. No packets are actually sent over UDP for
+// this, so it works without real connectivity to the dialed address.
+func outboundIP() (string, error) {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+func performMapTask(filename string, mapTaskId int, nReduce int, mapf func(string, string) []KeyValue,
+	partitionf func(string, int) int, combinef func(string, []string) string) bool {
 	// Read input file
 	file, err := os.Open(filename)
 	if err != nil {
@@ -76,13 +183,34 @@ func performMapTask(filename string, mapTaskId int, nReduce int, mapf func(strin
 	// Call Map function
 	kva := mapf(filename, string(content))
 
+	if partitionf == nil {
+		partitionf = defaultPartition
+	}
+
 	// Partition intermediate key/value pairs by reduce task
 	buckets := make([][]KeyValue, nReduce)
 	for _, kv := range kva {
-		reduceId := ihash(kv.Key) % nReduce
+		reduceId := partitionf(kv.Key, nReduce)
 		buckets[reduceId] = append(buckets[reduceId], kv)
 	}
 
+	// Combine each bucket locally before it hits disk, shrinking the
+	// on-disk intermediate size. Safe to skip: a nil combinef leaves the
+	// buckets untouched.
+	if combinef != nil {
+		for reduceId, bucket := range buckets {
+			buckets[reduceId] = combine(bucket, combinef)
+		}
+	}
+
+	// Sort each partition by key (in runs if it's larger than
+	// MaxInMemoryBytes; see sortBucket) so the file performReduceTask
+	// fetches is already sorted, letting it merge partitions in O(nMap) memory
+	// instead of loading everything and sorting on the reduce side.
+	for reduceId, bucket := range buckets {
+		buckets[reduceId] = sortBucket(bucket)
+	}
+
 	// Write intermediate files using temporary files and atomic rename
 	intermediateFiles := []*os.File{}
 	tempFiles := []string{}
@@ -145,58 +273,41 @@ func performMapTask(filename string, mapTaskId int, nReduce int, mapf func(strin
 	return true
 }
 
-func performReduceTask(reduceTaskId int, fileNames []string, reducef func(string, []string) string) bool {
-	// Read all intermediate files for this reduce task
-	kva := []KeyValue{}
-	for _, filename := range fileNames {
-		file, err := os.Open(filename)
-		if err != nil {
-			// File might not exist if map task hasn't completed or failed
-			// This is okay, we'll just skip it
-			continue
+// performReduceTask fetches this reduce partition's intermediate data
+// from each map worker's HTTP server and reduces it via a streaming
+// k-way merge (see mergeReduce), so memory usage is O(nMap) rather than
+// O(total intermediate size). On success it returns (true, nil). If a
+// fetch fails for locations[i], it returns (false, &locations[i].MapId)
+// so the caller can tell the coordinator which map task needs to be
+// re-run.
+func performReduceTask(reduceTaskId int, locations []Location, reducef func(string, []string) string) (bool, *int) {
+	sources := make([]*kvSource, 0, len(locations))
+	defer func() {
+		for _, s := range sources {
+			s.Close()
 		}
+	}()
 
-		dec := json.NewDecoder(file)
-		for {
-			var kv KeyValue
-			if err := dec.Decode(&kv); err != nil {
-				break
-			}
-			kva = append(kva, kv)
+	for _, loc := range locations {
+		src, err := openIntermediate(loc.URL)
+		if err != nil {
+			log.Printf("Cannot fetch map %v output from %v: %v", loc.MapId, loc.URL, err)
+			mapId := loc.MapId
+			return false, &mapId
 		}
-		file.Close()
+		sources = append(sources, src)
 	}
 
-	// Sort by key
-	sort.Sort(ByKey(kva))
-
 	// Create temporary output file
 	tempFile, err := ioutil.TempFile("", fmt.Sprintf("mr-out-%d-", reduceTaskId))
 	if err != nil {
 		log.Printf("Cannot create temp output file: %v", err)
-		return false
+		return false, nil
 	}
 	tempName := tempFile.Name()
 	defer tempFile.Close()
 
-	// Call Reduce on each distinct key
-	i := 0
-	for i < len(kva) {
-		j := i + 1
-		for j < len(kva) && kva[j].Key == kva[i].Key {
-			j++
-		}
-		values := []string{}
-		for k := i; k < j; k++ {
-			values = append(values, kva[k].Value)
-		}
-		output := reducef(kva[i].Key, values)
-
-		// Write output in the correct format
-		fmt.Fprintf(tempFile, "%v %v\n", kva[i].Key, output)
-
-		i = j
-	}
+	mergeReduce(sources, tempFile, reducef)
 	tempFile.Close()
 
 	// Atomically rename to final output file
@@ -205,22 +316,243 @@ func performReduceTask(reduceTaskId int, fileNames []string, reducef func(string
 	if err != nil {
 		log.Printf("Cannot rename output file to %v: %v", outputName, err)
 		os.Remove(tempName)
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
+}
+
+// openIntermediate fetches one map task's intermediate output for a
+// reduce partition over HTTP, retrying a couple of times against
+// transient errors (e.g. the map worker hasn't started its server yet)
+// before giving up. The returned kvSource streams KeyValue pairs rather
+// than buffering the whole response.
+func openIntermediate(url string) (*kvSource, error) {
+	const maxAttempts = 3
+	var lastErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("unexpected status fetching %v: %v", url, resp.Status)
+			continue
+		}
+		return &kvSource{dec: json.NewDecoder(resp.Body), Closer: resp.Body}, nil
+	}
+	return nil, lastErr
+}
+
+// kvSource streams sorted KeyValue pairs one at a time from an underlying
+// reader (an HTTP response body in production, an in-memory buffer in
+// tests), so a reduce task never needs to hold more than one pair per
+// source in memory.
+type kvSource struct {
+	dec *json.Decoder
+	io.Closer
+}
+
+// next returns the next KeyValue from the source, or ok=false once it's
+// exhausted.
+func (s *kvSource) next() (KeyValue, bool) {
+	var kv KeyValue
+	if err := s.dec.Decode(&kv); err != nil {
+		return KeyValue{}, false
+	}
+	return kv, true
+}
+
+// heapItem is one source's current head, tracked in a min-heap so
+// mergeReduce and mergeRuns can always pull the globally-smallest key
+// next without comparing every source directly.
+type heapItem struct {
+	kv     KeyValue
+	source int
+}
+
+type kvHeap []heapItem
+
+func (h kvHeap) Len() int           { return len(h) }
+func (h kvHeap) Less(i, j int) bool { return h[i].kv.Key < h[j].kv.Key }
+func (h kvHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *kvHeap) Push(x interface{}) { *h = append(*h, x.(heapItem)) }
+
+func (h *kvHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
 }
 
-func reportTask(taskType TaskType, taskId int, success bool) {
+// mergeReduce does a streaming k-way merge across sources (each already
+// sorted by key, per performMapTask's sortBucket) and calls reducef once
+// per distinct key as the merge passes over it, writing output to out.
+// Because it only ever holds one pending KeyValue per source, plus the
+// values accumulated for the current key, memory is O(len(sources) +
+// values-per-key) rather than O(total intermediate size).
+func mergeReduce(sources []*kvSource, out io.Writer, reducef func(string, []string) string) {
+	h := &kvHeap{}
+	heap.Init(h)
+	for i, s := range sources {
+		if kv, ok := s.next(); ok {
+			heap.Push(h, heapItem{kv: kv, source: i})
+		}
+	}
+
+	var curKey string
+	var curValues []string
+	haveCur := false
+
+	flush := func() {
+		if haveCur {
+			output := reducef(curKey, curValues)
+			fmt.Fprintf(out, "%v %v\n", curKey, output)
+		}
+	}
+
+	for h.Len() > 0 {
+		item := heap.Pop(h).(heapItem)
+		if next, ok := sources[item.source].next(); ok {
+			heap.Push(h, heapItem{kv: next, source: item.source})
+		}
+
+		if haveCur && item.kv.Key != curKey {
+			flush()
+			curValues = curValues[:0]
+		}
+		curKey = item.kv.Key
+		curValues = append(curValues, item.kv.Value)
+		haveCur = true
+	}
+	flush()
+}
+
+// sortBucket returns bucket's pairs in sorted-by-key order. If bucket is
+// larger than MaxInMemoryBytes, it's split into runs of about
+// MaxInMemoryBytes each, each run is sorted, and the runs are merged with
+// the same min-heap approach as mergeReduce. The runs stay in memory and
+// the merge builds a new slice holding the whole bucket; nothing is
+// written to disk.
+func sortBucket(bucket []KeyValue) []KeyValue {
+	if approxSize(bucket) <= MaxInMemoryBytes {
+		sort.Sort(ByKey(bucket))
+		return bucket
+	}
+
+	var runs [][]KeyValue
+	var run []KeyValue
+	var runSize int64
+	for _, kv := range bucket {
+		run = append(run, kv)
+		runSize += int64(len(kv.Key) + len(kv.Value))
+		if runSize >= MaxInMemoryBytes {
+			sort.Sort(ByKey(run))
+			runs = append(runs, run)
+			run = nil
+			runSize = 0
+		}
+	}
+	if len(run) > 0 {
+		sort.Sort(ByKey(run))
+		runs = append(runs, run)
+	}
+
+	return mergeRuns(runs)
+}
+
+// approxSize estimates, in bytes, how much memory kva's key/value strings
+// occupy -- good enough to decide whether to spill, without the cost of
+// exactly accounting for encoding overhead.
+func approxSize(kva []KeyValue) int64 {
+	var n int64
+	for _, kv := range kva {
+		n += int64(len(kv.Key) + len(kv.Value))
+	}
+	return n
+}
+
+// mergeRuns merges already-sorted runs into one sorted slice.
+func mergeRuns(runs [][]KeyValue) []KeyValue {
+	h := &kvHeap{}
+	heap.Init(h)
+	next := make([]int, len(runs))
+	total := 0
+	for i, run := range runs {
+		total += len(run)
+		if len(run) > 0 {
+			heap.Push(h, heapItem{kv: run[0], source: i})
+			next[i] = 1
+		}
+	}
+
+	merged := make([]KeyValue, 0, total)
+	for h.Len() > 0 {
+		item := heap.Pop(h).(heapItem)
+		merged = append(merged, item.kv)
+		if next[item.source] < len(runs[item.source]) {
+			heap.Push(h, heapItem{kv: runs[item.source][next[item.source]], source: item.source})
+			next[item.source]++
+		}
+	}
+	return merged
+}
+
+func registerWorker() (WorkerId, bool) {
+	args := RegisterWorkerArgs{}
+	reply := RegisterWorkerReply{}
+	if !call("Coordinator.RegisterWorker", &args, &reply) {
+		return 0, false
+	}
+	return reply.WorkerId, true
+}
+
+// sendHeartbeats pings the coordinator every heartbeatInterval so it
+// doesn't mistake this worker for dead. It returns once the coordinator
+// becomes unreachable; the main loop's own GetTask calls will notice the
+// same thing and exit.
+func sendHeartbeats(workerId WorkerId) {
+	for {
+		time.Sleep(heartbeatInterval)
+		args := HeartbeatArgs{WorkerId: workerId}
+		reply := HeartbeatReply{}
+		if !call("Coordinator.Heartbeat", &args, &reply) {
+			return
+		}
+	}
+}
+
+func reportMapTask(taskId int, workerId WorkerId, hostPort string, success bool) {
 	args := ReportTaskArgs{
-		TaskType: taskType,
+		TaskType: MapTask,
 		TaskId:   taskId,
+		WorkerId: workerId,
+		HostPort: hostPort,
 		Success:  success,
 	}
 	reply := ReportTaskReply{}
 	call("Coordinator.ReportTask", &args, &reply)
 }
 
+func reportReduceTask(taskId int, workerId WorkerId, failedMapId *int, success bool) {
+	args := ReportTaskArgs{
+		TaskType:    ReduceTask,
+		TaskId:      taskId,
+		WorkerId:    workerId,
+		FailedMapId: failedMapId,
+		Success:     success,
+	}
+	reply := ReportTaskReply{}
+	call("Coordinator.ReportTask", &args, &reply)
+}
+
 // For sorting by key
 type ByKey []KeyValue
 
@@ -0,0 +1,204 @@
+package mr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// wcMap and wcReduce mirror the classic mrapps/wc.go Map and a Reduce
+// that just sums counts, which is also a valid combiner: summing a
+// worker-local subset of counts and then summing the partial sums at
+// reduce time gives the same total.
+func wcMap(filename, contents string) []KeyValue {
+	words := strings.Fields(contents)
+	kva := make([]KeyValue, 0, len(words))
+	for _, w := range words {
+		kva = append(kva, KeyValue{Key: w, Value: "1"})
+	}
+	return kva
+}
+
+func wcReduce(key string, values []string) string {
+	sum := 0
+	for _, v := range values {
+		n, _ := strconv.Atoi(v)
+		sum += n
+	}
+	return strconv.Itoa(sum)
+}
+
+// TestCombinerShrinksIntermediateOutput checks that giving performMapTask
+// a combiner (wcReduce, applied locally) shrinks the intermediate files
+// it writes compared to running with no combiner at all.
+func TestCombinerShrinksIntermediateOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mr-combiner-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("the quick brown fox the lazy dog the ", 200)
+	inputPath := filepath.Join(dir, "input.txt")
+	if err := ioutil.WriteFile(inputPath, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	withoutCombiner := runMapTaskSize(t, dir, "nocombine", inputPath, nil)
+	withCombiner := runMapTaskSize(t, dir, "combine", inputPath, wcReduce)
+
+	if withCombiner >= withoutCombiner {
+		t.Fatalf("combiner did not shrink intermediate output: without=%d with=%d", withoutCombiner, withCombiner)
+	}
+}
+
+// runMapTaskSize runs performMapTask for a single map task in its own
+// sub-directory of parent (so repeat runs in the same test don't clobber
+// each other's mr-0-* files) and returns the total size, in bytes, of the
+// intermediate files it produced.
+func runMapTaskSize(t *testing.T, parent, name, inputPath string, combinef func(string, []string) string) int64 {
+	t.Helper()
+
+	runDir := filepath.Join(parent, name)
+	if err := os.Mkdir(runDir, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(runDir); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+
+	const nReduce = 3
+	if ok := performMapTask(inputPath, 0, nReduce, wcMap, nil, combinef); !ok {
+		t.Fatalf("performMapTask failed")
+	}
+
+	var total int64
+	for r := 0; r < nReduce; r++ {
+		info, err := os.Stat(fmt.Sprintf("mr-0-%d", r))
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		total += info.Size()
+	}
+	return total
+}
+
+// newMemorySource builds a kvSource over already-sorted kva, for tests
+// and benchmarks that exercise mergeReduce without an HTTP server behind
+// it.
+func newMemorySource(kva []KeyValue) *kvSource {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, kv := range kva {
+		enc.Encode(&kv)
+	}
+	return &kvSource{dec: json.NewDecoder(&buf), Closer: ioutil.NopCloser(&buf)}
+}
+
+// TestMergeReduceBoundedMemory checks the actual memory claim behind
+// mergeReduce's design, rather than just timing it: merge a large number
+// of pairs -- well beyond what the design is supposed to ever hold at
+// once -- and assert the heap mergeReduce itself accounts for stays
+// within a small, fixed bound. If mergeReduce regressed to buffering
+// every source in full (the O(total KV) behavior this request replaced),
+// this would fail regardless of how fast it ran.
+func TestMergeReduceBoundedMemory(t *testing.T) {
+	const numSources = 200
+	const perSource = 5000 // 1,000,000 pairs total; distinct keys per source repeat, so output stays small
+
+	sources := make([]*kvSource, numSources)
+	for s := 0; s < numSources; s++ {
+		kva := make([]KeyValue, perSource)
+		for k := range kva {
+			kva[k] = KeyValue{Key: fmt.Sprintf("key-%08d", k), Value: "1"}
+		}
+		sources[s] = newMemorySource(kva)
+	}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	// Sample the live heap (after a GC) every 500 keys while the merge is
+	// running, so memory mergeReduce holds during the merge counts, not
+	// only what is still reachable once it returns.
+	var peak uint64
+	keys := 0
+	sampledReduce := func(key string, values []string) string {
+		keys++
+		if keys%500 == 0 {
+			runtime.GC()
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > peak {
+				peak = m.HeapAlloc
+			}
+		}
+		return wcReduce(key, values)
+	}
+
+	var out bytes.Buffer
+	mergeReduce(sources, &out, sampledReduce)
+	// Keep the sources' buffers live through the merge so they stay in
+	// the baseline; otherwise a merge that copied every pair into memory
+	// would be offset by the consumed buffers being freed.
+	runtime.KeepAlive(sources)
+
+	runtime.GC()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+	if after.HeapAlloc > peak {
+		peak = after.HeapAlloc
+	}
+
+	// mergeReduce only ever holds one pending KeyValue per source plus
+	// the values accumulated for the current key, so its own live heap
+	// use should stay a small constant, not grow with the 1,000,000
+	// pairs it streamed through. The sources' backing bytes.Buffers are
+	// allocated before the "before" snapshot, so they're excluded; only
+	// mergeReduce's own working set (plus its output buffer, which is
+	// bounded by the number of distinct keys, not the pair count) counts
+	// here.
+	const maxBoundedBytes = 4 << 20 // 4MB
+	if peak > before.HeapAlloc && peak-before.HeapAlloc > maxBoundedBytes {
+		t.Fatalf("mergeReduce held up to %d bytes of heap while merging %d sources x %d pairs; want < %d (bounded, independent of total pair count)",
+			peak-before.HeapAlloc, numSources, perSource, maxBoundedBytes)
+	}
+}
+
+// BenchmarkReduceMerge times mergeReduce's streaming k-way merge across
+// many sorted sources. It stands in for the design's original synthetic
+// 10GB job -- too slow to run as part of the normal test suite -- at a
+// scale that still exercises the merge path; see
+// TestMergeReduceBoundedMemory for the actual bounded-memory assertion.
+func BenchmarkReduceMerge(b *testing.B) {
+	const numSources = 50
+	const perSource = 2000
+
+	for i := 0; i < b.N; i++ {
+		sources := make([]*kvSource, numSources)
+		for s := 0; s < numSources; s++ {
+			kva := make([]KeyValue, perSource)
+			for k := range kva {
+				kva[k] = KeyValue{Key: fmt.Sprintf("key-%06d", k), Value: "1"}
+			}
+			sources[s] = newMemorySource(kva)
+		}
+
+		var out bytes.Buffer
+		mergeReduce(sources, &out, wcReduce)
+	}
+}